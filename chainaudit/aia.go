@@ -0,0 +1,126 @@
+package chainaudit
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Resolver fetches missing intermediates via a leaf certificate's
+// Authority Information Access caIssuers URLs and attempts to build a
+// chain that verifies against a trusted root pool, mirroring the
+// approach cfssl's bundler takes in BundleFromRemote. It exists so a
+// server that simply omits its intermediate isn't misreported as
+// serving the wrong one.
+type Resolver struct {
+	// Roots is the pool of trusted root certificates resolved chains
+	// are verified against.
+	Roots *x509.CertPool
+
+	// CacheDir, if non-empty, is a directory where fetched
+	// intermediates are cached (keyed by a digest of their source
+	// URL) so a large TSV scan doesn't re-download the same
+	// intermediate thousands of times.
+	CacheDir string
+
+	client *http.Client
+}
+
+// NewResolver returns a Resolver that verifies resolved chains against
+// roots, caching fetched intermediates under cacheDir.
+func NewResolver(roots *x509.CertPool, cacheDir string) *Resolver {
+	return &Resolver{
+		Roots:    roots,
+		CacheDir: cacheDir,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve follows leaf's AIA caIssuers URLs, fetching and caching each
+// candidate intermediate, and returns the first intermediate chain (leaf
+// excluded) that verifies against r.Roots. It returns an error if no
+// caIssuers URL yields a verifiable chain.
+func (r *Resolver) Resolve(leaf *x509.Certificate) ([]*x509.Certificate, error) {
+	if len(leaf.IssuingCertificateURL) == 0 {
+		return nil, fmt.Errorf("leaf %q has no AIA caIssuers URL", leaf.Subject.CommonName)
+	}
+
+	var lastErr error
+	for _, url := range leaf.IssuingCertificateURL {
+		intermediate, err := r.fetch(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		pool := x509.NewCertPool()
+		pool.AddCert(intermediate)
+		chains, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         r.Roots,
+			Intermediates: pool,
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// chains[0][0] is the leaf itself; the rest is the resolved
+		// path up to (and including) the root.
+		return chains[0][1:], nil
+	}
+	return nil, fmt.Errorf("no caIssuers URL resolved to a verifiable chain: %s", lastErr)
+}
+
+// fetch retrieves the certificate at url, preferring a cached copy over
+// re-downloading.
+func (r *Resolver) fetch(url string) (*x509.Certificate, error) {
+	cachePath := ""
+	if r.CacheDir != "" {
+		sum := sha256.Sum256([]byte(url))
+		cachePath = filepath.Join(r.CacheDir, hex.EncodeToString(sum[:])+".der")
+		if data, err := ioutil.ReadFile(cachePath); err == nil {
+			return x509.ParseCertificate(data)
+		}
+	}
+
+	resp, err := r.client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading body from %s: %s", url, err)
+	}
+
+	der := body
+	if block, _ := pem.Decode(body); block != nil {
+		der = block.Bytes
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate from %s: %s", url, err)
+	}
+
+	if cachePath != "" {
+		err = ioutil.WriteFile(cachePath, der, 0644)
+		if err != nil {
+			// Caching is purely an optimization; a failure to write
+			// it shouldn't fail the audit.
+			fmt.Fprintf(os.Stderr, "warning: couldn't cache intermediate from %s: %s\n", url, err)
+		}
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,206 @@
+// Package chainaudit audits presented TLS certificate chains against a
+// configured set of rules describing which intermediates are allowed to
+// have issued a leaf for a given issuer Common Name. It exists so that
+// operators can detect a CA serving an unexpected intermediate (e.g.
+// during a Let's Encrypt intermediate rotation) across any number of
+// issuers in a single pass, rather than hardcoding one issuer CN at a
+// time.
+package chainaudit
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Rule describes the set of intermediates a CA is expected to use when
+// signing leaf certificates whose Issuer Common Name is IssuerCN.
+type Rule struct {
+	// IssuerCN is the Issuer Common Name presented on the leaf
+	// certificate, e.g. "R3" or "E1".
+	IssuerCN string `json:"issuerCN"`
+
+	// AllowedSubjectCNs lists the Subject Common Names of
+	// intermediates permitted to have issued a leaf matching
+	// IssuerCN.
+	AllowedSubjectCNs []string `json:"allowedSubjectCNs"`
+
+	// AllowedFingerprints lists the hex-encoded SHA256 fingerprints
+	// of the SubjectPublicKeyInfo of intermediates permitted to have
+	// issued a leaf matching IssuerCN. A chain may match on either a
+	// fingerprint or a Subject CN.
+	AllowedFingerprints []string `json:"allowedFingerprints"`
+}
+
+// Config is the set of Rules an auditor checks presented chains
+// against.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadConfig reads and parses a Config from the JSON file at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading chainaudit config: %s", err)
+	}
+	var cfg Config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("parsing chainaudit config: %s", err)
+	}
+	return &cfg, nil
+}
+
+// ruleFor returns the Rule matching issuerCN, or nil if no rule covers
+// it.
+func (c *Config) ruleFor(issuerCN string) *Rule {
+	for i, rule := range c.Rules {
+		if rule.IssuerCN == issuerCN {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// Verdict enumerates the possible outcomes of auditing a chain.
+type Verdict string
+
+const (
+	// VerdictOK means an intermediate in the presented (or resolved)
+	// chain matched an allowed Subject CN or fingerprint for the
+	// leaf's issuer.
+	VerdictOK Verdict = "ok"
+
+	// VerdictWrongIntermediate means the leaf's issuer CN matched a
+	// rule, the server presented an intermediate, but it didn't match
+	// an allowed Subject CN or fingerprint for that rule.
+	VerdictWrongIntermediate Verdict = "wrong_intermediate"
+
+	// VerdictIncomplete means the server presented only the leaf
+	// certificate and no Resolver was configured to attempt AIA
+	// resolution.
+	VerdictIncomplete Verdict = "incomplete_chain"
+
+	// VerdictMissingIntermediateResolved means the server presented
+	// only the leaf certificate, but following its AIA caIssuers
+	// URL(s) resolved a chain whose intermediate matched an allowed
+	// Subject CN or fingerprint for the matched rule.
+	VerdictMissingIntermediateResolved Verdict = "missing_intermediate_resolved"
+
+	// VerdictMissingIntermediateUnresolved means the server presented
+	// only the leaf certificate, and AIA resolution either failed or
+	// resolved to an intermediate that didn't match the matched rule.
+	VerdictMissingIntermediateUnresolved Verdict = "missing_intermediate_unresolved"
+
+	// VerdictNoRule means the leaf's issuer CN didn't match any
+	// configured rule, so no audit could be performed.
+	VerdictNoRule Verdict = "no_rule"
+)
+
+// Result is the structured outcome of auditing a single certificate
+// chain against a Config.
+type Result struct {
+	// LeafCN is the Subject Common Name of the leaf certificate.
+	LeafCN string
+
+	// PresentedIssuerCN is the Issuer Common Name on the leaf
+	// certificate.
+	PresentedIssuerCN string
+
+	// ExpectedSubjectCNs lists the Subject Common Names allowed by the
+	// rule that matched PresentedIssuerCN, if any.
+	ExpectedSubjectCNs []string
+
+	// MatchedFingerprint is the hex-encoded SHA256 SPKI fingerprint of
+	// the intermediate that satisfied the rule, set only when Verdict
+	// is VerdictOK or VerdictMissingIntermediateResolved.
+	MatchedFingerprint string
+
+	// Verdict is the outcome of the audit.
+	Verdict Verdict
+}
+
+// SPKIFingerprint returns the hex-encoded SHA256 digest of a
+// certificate's SubjectPublicKeyInfo, the same value Rule.AllowedFingerprints
+// entries are compared against.
+func SPKIFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// contains reports whether needle is present in haystack.
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRule reports whether any certificate in certs satisfies rule,
+// returning the matching certificate's fingerprint if so.
+func matchRule(rule *Rule, certs []*x509.Certificate) (string, bool) {
+	for _, cert := range certs {
+		fp := SPKIFingerprint(cert)
+		if contains(rule.AllowedFingerprints, fp) || contains(rule.AllowedSubjectCNs, cert.Subject.CommonName) {
+			return fp, true
+		}
+	}
+	return "", false
+}
+
+// AuditChain audits chain, a leaf certificate followed by zero or more
+// intermediates in presentation order, against c's rules and returns a
+// structured Result describing the outcome. If the server presented only
+// the leaf certificate and resolver is non-nil, AuditChain follows the
+// leaf's AIA caIssuers URL(s) via resolver before concluding the
+// intermediate is missing, distinguishing a server that omitted a valid
+// intermediate from one serving the wrong one. Pass a nil resolver to
+// skip AIA resolution entirely.
+func (c *Config) AuditChain(chain []*x509.Certificate, resolver *Resolver) Result {
+	leaf := chain[0]
+	result := Result{
+		LeafCN:            leaf.Subject.CommonName,
+		PresentedIssuerCN: leaf.Issuer.CommonName,
+	}
+
+	rule := c.ruleFor(leaf.Issuer.CommonName)
+	if rule == nil {
+		result.Verdict = VerdictNoRule
+		return result
+	}
+	result.ExpectedSubjectCNs = rule.AllowedSubjectCNs
+
+	if len(chain) >= 2 {
+		if fp, ok := matchRule(rule, chain[1:]); ok {
+			result.MatchedFingerprint = fp
+			result.Verdict = VerdictOK
+			return result
+		}
+		result.Verdict = VerdictWrongIntermediate
+		return result
+	}
+
+	if resolver == nil {
+		result.Verdict = VerdictIncomplete
+		return result
+	}
+
+	resolved, err := resolver.Resolve(leaf)
+	if err != nil {
+		result.Verdict = VerdictMissingIntermediateUnresolved
+		return result
+	}
+	if fp, ok := matchRule(rule, resolved); ok {
+		result.MatchedFingerprint = fp
+		result.Verdict = VerdictMissingIntermediateResolved
+		return result
+	}
+	result.Verdict = VerdictMissingIntermediateUnresolved
+	return result
+}
@@ -0,0 +1,93 @@
+package chainaudit
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+// fakeCert builds a *x509.Certificate with just enough populated to
+// exercise AuditChain: Subject/Issuer Common Names and a distinguishing
+// (but not actually valid) SubjectPublicKeyInfo.
+func fakeCert(subjectCN, issuerCN, spki string) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:                 pkix.Name{CommonName: subjectCN},
+		Issuer:                  pkix.Name{CommonName: issuerCN},
+		RawSubjectPublicKeyInfo: []byte(spki),
+	}
+}
+
+func TestAuditChainOKByFingerprint(t *testing.T) {
+	intermediate := fakeCert("R3", "ISRG Root X1", "r3-spki")
+	cfg := &Config{Rules: []Rule{
+		{
+			IssuerCN:            "R3",
+			AllowedFingerprints: []string{SPKIFingerprint(intermediate)},
+		},
+	}}
+
+	leaf := fakeCert("example.com", "R3", "leaf-spki")
+	result := cfg.AuditChain([]*x509.Certificate{leaf, intermediate}, nil)
+
+	if result.Verdict != VerdictOK {
+		t.Errorf("Verdict = %s, want %s", result.Verdict, VerdictOK)
+	}
+	if result.MatchedFingerprint != SPKIFingerprint(intermediate) {
+		t.Errorf("MatchedFingerprint = %q, want %q", result.MatchedFingerprint, SPKIFingerprint(intermediate))
+	}
+}
+
+func TestAuditChainOKBySubjectCN(t *testing.T) {
+	cfg := &Config{Rules: []Rule{
+		{IssuerCN: "R3", AllowedSubjectCNs: []string{"R3"}},
+	}}
+
+	leaf := fakeCert("example.com", "R3", "leaf-spki")
+	intermediate := fakeCert("R3", "ISRG Root X1", "r3-spki")
+	result := cfg.AuditChain([]*x509.Certificate{leaf, intermediate}, nil)
+
+	if result.Verdict != VerdictOK {
+		t.Errorf("Verdict = %s, want %s", result.Verdict, VerdictOK)
+	}
+}
+
+func TestAuditChainWrongIntermediate(t *testing.T) {
+	cfg := &Config{Rules: []Rule{
+		{IssuerCN: "R3", AllowedSubjectCNs: []string{"R3"}},
+	}}
+
+	leaf := fakeCert("example.com", "R3", "leaf-spki")
+	wrongIntermediate := fakeCert("E1", "ISRG Root X2", "e1-spki")
+	result := cfg.AuditChain([]*x509.Certificate{leaf, wrongIntermediate}, nil)
+
+	if result.Verdict != VerdictWrongIntermediate {
+		t.Errorf("Verdict = %s, want %s", result.Verdict, VerdictWrongIntermediate)
+	}
+}
+
+func TestAuditChainIncompleteWithoutResolver(t *testing.T) {
+	cfg := &Config{Rules: []Rule{
+		{IssuerCN: "R3", AllowedSubjectCNs: []string{"R3"}},
+	}}
+
+	leaf := fakeCert("example.com", "R3", "leaf-spki")
+	result := cfg.AuditChain([]*x509.Certificate{leaf}, nil)
+
+	if result.Verdict != VerdictIncomplete {
+		t.Errorf("Verdict = %s, want %s", result.Verdict, VerdictIncomplete)
+	}
+}
+
+func TestAuditChainNoRule(t *testing.T) {
+	cfg := &Config{Rules: []Rule{
+		{IssuerCN: "R3", AllowedSubjectCNs: []string{"R3"}},
+	}}
+
+	leaf := fakeCert("example.com", "Some Other CA", "leaf-spki")
+	intermediate := fakeCert("Some Other CA Intermediate", "Some Other Root", "other-spki")
+	result := cfg.AuditChain([]*x509.Certificate{leaf, intermediate}, nil)
+
+	if result.Verdict != VerdictNoRule {
+		t.Errorf("Verdict = %s, want %s", result.Verdict, VerdictNoRule)
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmhodges/clock"
@@ -20,6 +24,55 @@ type contactAuditor struct {
 	dbMap *db.WrappedMap
 	clk   clock.Clock
 	grace time.Duration
+
+	// validator, if non-nil, is run against every syntactically valid
+	// address collectContacts finds to check MX reachability and,
+	// unless running in dry-run mode, SMTP deliverability.
+	validator AddressValidator
+
+	// reportPath, if non-empty, is the file run's validation results
+	// are written to, in reportFormat.
+	reportPath   string
+	reportFormat string
+}
+
+// addressReport is one entry of the validation report written once run
+// completes: the registration ID an address was associated with, plus
+// the AddressValidator's verdict on that address.
+type addressReport struct {
+	ID int64 `json:"id"`
+	ValidationResult
+}
+
+// writeReport writes reports to path as JSON, or as CSV if format is
+// "csv".
+func writeReport(path, format string, reports []addressReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating report file: %s", err)
+	}
+	defer f.Close()
+
+	if format == "csv" {
+		w := csv.NewWriter(f)
+		w.Write([]string{"id", "address", "syntactic_ok", "mx_ok", "smtp_code", "smtp_message"})
+		for _, r := range reports {
+			w.Write([]string{
+				strconv.FormatInt(r.ID, 10),
+				r.Address,
+				strconv.FormatBool(r.SyntacticOK),
+				strconv.FormatBool(r.MXOK),
+				strconv.Itoa(r.SMTPCode),
+				r.SMTPMessage,
+			})
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
 }
 
 // queryResult is receiver for gorp select queries.
@@ -78,27 +131,87 @@ func (r *queryResult) unmarshalAddresses() error {
 	return nil
 }
 
+// addressJob is a single e-mail address queued for validation, tagged
+// with the registration ID it came from.
+type addressJob struct {
+	id      int64
+	address string
+}
+
 // run extracts email addresses from the database and attempts to
-// validate each.
+// validate each across a pool of workers. If e.validator is set, every
+// syntactically valid address is additionally checked for MX
+// reachability and (unless running in dry-run mode) SMTP deliverability,
+// and the results written to e.reportPath. The worker pool is what makes
+// smtpValidator's per-domain semaphore meaningful: without concurrent
+// callers, a real registrations table's worth of addresses would each
+// pay their dial and SMTP timeouts in series.
 func (e contactAuditor) run() (queryResults, error) {
 	results, err := e.collectContacts()
 	if err != nil {
 		return nil, err
 	}
+
+	var jobs []addressJob
 	for _, result := range results {
 		err = result.unmarshalAddresses()
 		if err != nil {
 			return nil, err
 		}
 		for _, address := range result.addresses {
-			err := policy.ValidEmail(address)
-			if err != nil {
-				fmt.Printf(
-					"validation failed for address: %q for ID: %d for reason: %q\n", address, result.ID, err)
-				continue
+			jobs = append(jobs, addressJob{id: result.ID, address: address})
+		}
+	}
+
+	jobChan := make(chan addressJob, len(jobs))
+	reportChan := make(chan addressReport)
+	doneChan := make(chan bool, 1)
+
+	go func() {
+		for _, job := range jobs {
+			jobChan <- job
+		}
+		close(jobChan)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobChan {
+				err := policy.ValidEmail(job.address)
+				if err != nil {
+					fmt.Printf(
+						"validation failed for address: %q for ID: %d for reason: %q\n", job.address, job.id, err)
+					reportChan <- addressReport{ID: job.id, ValidationResult: ValidationResult{Address: job.address}}
+					continue
+				}
+				if e.validator != nil {
+					reportChan <- addressReport{ID: job.id, ValidationResult: e.validator.Validate(job.address)}
+				}
 			}
+		}()
+	}
+
+	var reports []addressReport
+	go func() {
+		for report := range reportChan {
+			reports = append(reports, report)
+		}
+		doneChan <- true
+	}()
+	wg.Wait()
+	close(reportChan)
+	<-doneChan
+
+	if e.reportPath != "" {
+		err = writeReport(e.reportPath, e.reportFormat, reports)
+		if err != nil {
+			return nil, err
 		}
 	}
+
 	return results, nil
 }
 
@@ -108,9 +221,22 @@ func main() {
 			DB cmd.DBConfig
 			cmd.PasswordConfig
 			Features map[string]bool
+
+			// SMTPCacheTTL controls how long a domain's MX
+			// resolution is reused before being refreshed.
+			SMTPCacheTTL cmd.ConfigDuration
+
+			// ReportPath is the file validation results are written
+			// to. If empty, no report is written.
+			ReportPath string
+
+			// ReportFormat is either "json" (the default) or "csv".
+			ReportFormat string
 		}
 	}
 	configFile := flag.String("config", "", "File containing a JSON config.")
+	dryRun := flag.Bool("dry-run", false, "Skip the SMTP probe step; only check MX/A/AAAA reachability")
+	fromAddr := flag.String("from", "", "MAIL FROM address to use for SMTP probes")
 	flag.Parse()
 
 	configData, err := ioutil.ReadFile(*configFile)
@@ -138,7 +264,16 @@ func main() {
 		log.Fatalln("Could not connect to database")
 	}
 
-	auditor := contactAuditor{grace: 2 * 24 * time.Hour, clk: clock.New(), dbMap: dbMap}
+	validator := newSMTPValidator(*fromAddr, *dryRun, cfg.ContactAuditor.SMTPCacheTTL.Duration)
+
+	auditor := contactAuditor{
+		grace:        2 * 24 * time.Hour,
+		clk:          clock.New(),
+		dbMap:        dbMap,
+		validator:    validator,
+		reportPath:   cfg.ContactAuditor.ReportPath,
+		reportFormat: cfg.ContactAuditor.ReportFormat,
+	}
 	_, err = auditor.run()
 	if err != nil {
 		log.Fatalf("Problem encountered while running audit: %s\n", err)
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValidationResult is the outcome of validating a single e-mail address
+// beyond the syntactic and policy checks policy.ValidEmail already
+// performs.
+type ValidationResult struct {
+	Address     string `json:"address"`
+	SyntacticOK bool   `json:"syntactic_ok"`
+	MXOK        bool   `json:"mx_ok"`
+	SMTPCode    int    `json:"smtp_code,omitempty"`
+	SMTPMessage string `json:"smtp_message,omitempty"`
+}
+
+// AddressValidator validates a single e-mail address beyond the
+// syntactic and policy checks policy.ValidEmail already performs.
+type AddressValidator interface {
+	Validate(address string) ValidationResult
+}
+
+// mxCacheEntry is a domain's cached MX (or A/AAAA fallback) resolution.
+type mxCacheEntry struct {
+	hosts   []string
+	ok      bool
+	expires time.Time
+}
+
+// smtpValidator resolves MX records for an address's domain and,
+// unless DryRun is set, opens an SMTP connection to the first mail
+// exchanger and issues an EHLO/MAIL FROM/RCPT TO/QUIT sequence to record
+// the server's verdict on the address, without ever sending DATA.
+type smtpValidator struct {
+	// From is used as the MAIL FROM address in the SMTP probe.
+	From string
+
+	// DryRun, if true, skips the SMTP probe entirely; only MX/A/AAAA
+	// resolution is performed.
+	DryRun bool
+
+	// CacheTTL controls how long a domain's MX resolution is reused
+	// before being refreshed.
+	CacheTTL time.Duration
+
+	mu        sync.Mutex
+	mxCache   map[string]mxCacheEntry
+	domainSem map[string]chan struct{}
+}
+
+// newSMTPValidator returns an smtpValidator that uses from as the SMTP
+// MAIL FROM address and caches per-domain MX resolutions for cacheTTL.
+func newSMTPValidator(from string, dryRun bool, cacheTTL time.Duration) *smtpValidator {
+	return &smtpValidator{
+		From:      from,
+		DryRun:    dryRun,
+		CacheTTL:  cacheTTL,
+		mxCache:   make(map[string]mxCacheEntry),
+		domainSem: make(map[string]chan struct{}),
+	}
+}
+
+// domainOf returns the portion of address after the last '@', or "" if
+// address has no '@'.
+func domainOf(address string) string {
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return ""
+	}
+	return address[i+1:]
+}
+
+// Validate resolves address's domain and, unless v.DryRun is set,
+// serializes a single SMTP probe per domain through a one-slot
+// semaphore so a bulk run never opens concurrent connections to the
+// same mail provider.
+func (v *smtpValidator) Validate(address string) ValidationResult {
+	result := ValidationResult{Address: address, SyntacticOK: true}
+
+	domain := domainOf(address)
+	if domain == "" {
+		result.SyntacticOK = false
+		return result
+	}
+
+	hosts, mxOK := v.resolveMX(domain)
+	result.MXOK = mxOK
+	if !mxOK || v.DryRun {
+		return result
+	}
+
+	sem := v.semaphoreFor(domain)
+	sem <- struct{}{}
+	defer func() { <-sem }()
+
+	code, msg, err := probeSMTP(hosts[0], v.From, address)
+	if err != nil {
+		result.SMTPMessage = err.Error()
+		return result
+	}
+	result.SMTPCode = code
+	result.SMTPMessage = msg
+	return result
+}
+
+// resolveMX returns the cached MX lookup for domain, performing and
+// caching a fresh one if the cached entry is missing or has expired.
+func (v *smtpValidator) resolveMX(domain string) ([]string, bool) {
+	v.mu.Lock()
+	entry, ok := v.mxCache[domain]
+	v.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.hosts, entry.ok
+	}
+
+	hosts, err := mxHosts(domain)
+	entry = mxCacheEntry{hosts: hosts, ok: err == nil, expires: time.Now().Add(v.CacheTTL)}
+
+	v.mu.Lock()
+	v.mxCache[domain] = entry
+	v.mu.Unlock()
+	return entry.hosts, entry.ok
+}
+
+// semaphoreFor returns the single-slot channel used to serialize
+// connections to domain's mail servers, creating it on first use.
+func (v *smtpValidator) semaphoreFor(domain string) chan struct{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	sem, ok := v.domainSem[domain]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		v.domainSem[domain] = sem
+	}
+	return sem
+}
+
+// mxHosts returns the mail exchangers for domain, falling back to the
+// domain's own A/AAAA records per RFC 5321 section 5.1 when it has no MX
+// records.
+func mxHosts(domain string) ([]string, error) {
+	mxs, err := net.LookupMX(domain)
+	if err == nil && len(mxs) > 0 {
+		hosts := make([]string, len(mxs))
+		for i, mx := range mxs {
+			hosts[i] = strings.TrimSuffix(mx.Host, ".")
+		}
+		return hosts, nil
+	}
+
+	if _, aErr := net.LookupHost(domain); aErr == nil {
+		return []string{domain}, nil
+	}
+	return nil, fmt.Errorf("no MX or A/AAAA records for %q", domain)
+}
+
+// smtpReadMultiline reads lines from r until one without a hyphen in the
+// fourth column (the terminator of a multi-line SMTP reply), optionally
+// requiring the reply code to start with prefix. It returns the final
+// (terminating) line.
+func smtpReadMultiline(r *bufio.Reader, prefix string) (string, error) {
+	var last string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("reading SMTP response: %s", err)
+		}
+		if prefix != "" && !strings.HasPrefix(line, prefix) {
+			return "", fmt.Errorf("unexpected SMTP response: %q", line)
+		}
+		last = line
+		if len(line) > 3 && line[3] != '-' {
+			break
+		}
+	}
+	return last, nil
+}
+
+// probeSMTP dials host on port 25 and issues EHLO, MAIL FROM, RCPT TO,
+// and QUIT, returning the reply code and message for the RCPT TO command
+// without ever sending DATA.
+func probeSMTP(host, from, address string) (int, string, error) {
+	conn, err := net.DialTimeout("tcp", host+":25", 5*time.Second)
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(15 * time.Second))
+
+	r := bufio.NewReader(conn)
+	if _, err := smtpReadMultiline(r, "220"); err != nil {
+		return 0, "", err
+	}
+
+	if _, err := fmt.Fprintf(conn, "EHLO auditor\r\n"); err != nil {
+		return 0, "", err
+	}
+	if _, err := smtpReadMultiline(r, "250"); err != nil {
+		return 0, "", err
+	}
+
+	if _, err := fmt.Fprintf(conn, "MAIL FROM:<%s>\r\n", from); err != nil {
+		return 0, "", err
+	}
+	if _, err := smtpReadMultiline(r, "250"); err != nil {
+		return 0, "", err
+	}
+
+	if _, err := fmt.Fprintf(conn, "RCPT TO:<%s>\r\n", address); err != nil {
+		return 0, "", err
+	}
+	line, err := smtpReadMultiline(r, "")
+	if err != nil {
+		return 0, "", err
+	}
+
+	fmt.Fprintf(conn, "QUIT\r\n")
+
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		return 0, "", fmt.Errorf("parsing SMTP reply code from %q: %s", line, err)
+	}
+	return code, strings.TrimSpace(line[3:]), nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/sre-tools/chainaudit"
+)
+
+// crtshEntry is a single row of crt.sh's JSON search API response. Only
+// the fields needed to extract currently-unexpired SANs, and to scope
+// results to a particular issuer, are decoded.
+type crtshEntry struct {
+	NameValue  string `json:"name_value"`
+	NotAfter   string `json:"not_after"`
+	IssuerName string `json:"issuer_name"`
+}
+
+// crtshNotAfterLayout is the timestamp format crt.sh's JSON API uses for
+// not_after.
+const crtshNotAfterLayout = "2006-01-02T15:04:05"
+
+// crtshHostnames queries crt.sh for every certificate logged for domain
+// and returns the deduplicated set of SANs (wildcard labels stripped)
+// from certificates that haven't yet expired and whose issuer_name
+// contains one of issuerCNs. A nil or empty issuerCNs disables issuer
+// scoping entirely, matching every logged certificate.
+//
+// crt.sh's public search API has no notion of a CA account or
+// registration ID, so this can't scope discovery to "certs issued under
+// account N" as requested; issuer_name substring matching against the
+// chainaudit config's rule IssuerCNs is the closest available proxy, and
+// still excludes certificates from unrelated CAs.
+func crtshHostnames(domain string, issuerCNs []string) ([]string, error) {
+	q := fmt.Sprintf("https://crt.sh/?q=%s&output=json", url.QueryEscape(domain))
+	resp, err := http.Get(q)
+	if err != nil {
+		return nil, fmt.Errorf("querying crt.sh for %q: %s", domain, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("querying crt.sh for %q: status %d", domain, resp.StatusCode)
+	}
+
+	var entries []crtshEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding crt.sh response for %q: %s", domain, err)
+	}
+
+	seen := map[string]bool{}
+	var hostnames []string
+	for _, entry := range entries {
+		notAfter, err := time.Parse(crtshNotAfterLayout, entry.NotAfter)
+		if err == nil && notAfter.Before(time.Now()) {
+			continue
+		}
+		if len(issuerCNs) > 0 && !issuerNameMatches(entry.IssuerName, issuerCNs) {
+			continue
+		}
+		for _, name := range strings.Split(entry.NameValue, "\n") {
+			name = strings.TrimSpace(strings.TrimPrefix(name, "*."))
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			hostnames = append(hostnames, name)
+		}
+	}
+	return hostnames, nil
+}
+
+// issuerNameMatches reports whether issuerName (crt.sh's full issuer DN,
+// e.g. "C=US, O=Let's Encrypt, CN=R3") contains the Common Name of any of
+// issuerCNs.
+func issuerNameMatches(issuerName string, issuerCNs []string) bool {
+	for _, cn := range issuerCNs {
+		if strings.Contains(issuerName, "CN="+cn) {
+			return true
+		}
+	}
+	return false
+}
+
+// runDiscover implements the "discover" subcommand: given one or more
+// registered domains, it queries crt.sh for every currently-unexpired
+// certificate logged for each, extracts their SANs, and feeds the
+// resulting hostnames into the usual audit worker pool instead of
+// requiring a stats-exporter TSV.
+//
+// Discovery is scoped by issuer, not by CA account or registration ID:
+// unless -all-issuers is set, only certificates whose crt.sh issuer_name
+// matches one of -config's rule IssuerCNs are kept. crt.sh's public
+// search API has no account-scoped endpoint, so this is the narrower,
+// issuer-CN-based proxy for "certs chaining to a given intermediate"
+// rather than the account-scoped query the operational need calls for.
+func runDiscover(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	fs.BoolVar(&debugMode, "debug", false, "Print full audit output for every hostname")
+	configFile := fs.String("config", "", "path to a JSON file of chainaudit rules")
+	rootsFile := fs.String("roots", "", "path to a PEM bundle of trusted roots, used to resolve intermediates omitted by a server via AIA")
+	cacheDir := fs.String("cache-dir", "", "directory to cache AIA-fetched intermediates in, keyed by source URL")
+	protoFlag := fs.String("protocol", "https", "protocol to audit: https, smtp, submission, imap, pop3, xmpp")
+	port := fs.String("port", "", "port to dial; defaults to the conventional port for -protocol")
+	outputFormat := fs.String("output", "text", "output format: text, json, ndjson, csv")
+	allIssuers := fs.Bool("all-issuers", false, "don't scope crt.sh results to -config's rule issuer CNs; discover every logged cert regardless of issuer (crt.sh has no CA account/registration ID scoped endpoint, so even with scoping this is a coarser proxy for \"certs chaining to a given intermediate\")")
+	fs.Parse(args)
+
+	domains := fs.Args()
+	if len(domains) == 0 {
+		log.Fatalln("Usage: intermediary-auditor discover [flags] <domain> [<domain> ...]")
+	}
+
+	switch *outputFormat {
+	case "text", "json", "ndjson", "csv":
+	default:
+		log.Fatalf("Unknown -output format %q\n", *outputFormat)
+	}
+
+	if *configFile == "" {
+		log.Fatalln("You must supply a rule config via -config")
+	}
+	cfg, err := chainaudit.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var resolver *chainaudit.Resolver
+	if *rootsFile != "" {
+		roots, err := loadRoots(*rootsFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		resolver = chainaudit.NewResolver(roots, *cacheDir)
+	}
+
+	var issuerCNs []string
+	if !*allIssuers {
+		for _, rule := range cfg.Rules {
+			issuerCNs = append(issuerCNs, rule.IssuerCN)
+		}
+	}
+
+	seen := map[string]bool{}
+	var hostnames []string
+	for _, domain := range domains {
+		found, err := crtshHostnames(domain, issuerCNs)
+		if err != nil {
+			log.Printf("discovering hosts for %q: %s\n", domain, err)
+			continue
+		}
+		for _, hostname := range found {
+			if !seen[hostname] {
+				seen[hostname] = true
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+	if len(hostnames) == 0 {
+		log.Fatalln("No hostnames discovered via crt.sh")
+	}
+
+	p := protocol(*protoFlag)
+	if *port == "" {
+		*port = defaultPort(p)
+	}
+
+	auditHostnames(cfg, resolver, p, *port, *outputFormat, hostnames)
+}
@@ -7,31 +7,19 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"strings"
 	"sync"
 	"time"
-)
 
-const (
-	r3 = "R3"
+	"github.com/letsencrypt/sre-tools/chainaudit"
 )
 
 var debugMode bool
 
-// chainContainsR3 checks if a chain of certs contains a certificate
-// where the Subject Common Name matches the const of r3
-func chainContainsR3(chain []*x509.Certificate) bool {
-	for _, cert := range chain[1:] {
-		if cert.Subject.CommonName == r3 {
-			return true
-		}
-	}
-	return false
-}
-
 // rawToChain marshals a slice of byte slices representing an x.509
 // certificate chain to a slice of *x.509Certificate objects
 func rawToChain(rawCerts [][]byte) []*x509.Certificate {
@@ -59,43 +47,101 @@ func chaing2String(chain []*x509.Certificate) string {
 	return sb.String()
 }
 
-// auditChain for a given slice of byte slices representing an x.509
-// certificate chain, if the Issuer Common Name is const r3, validates
-// that the resulting chain of x509 Certificates contains the
-// corresponding r3 intermediate that issued the leaf Certificate. If a
-// mis-match is present, a string containing the Subject Common Name of
-// the leaf certificate is returned, else, in all other cases an empty
-// string is returned.
-func auditChain(rawCerts [][]byte) string {
-	chain := rawToChain(rawCerts)
-	leafIssuerCN := chain[0].Issuer.CommonName
-	if len(chain) > 1 {
-		if debugMode == true {
-			fmt.Println(chaing2String(chain))
-		}
-		if leafIssuerCN == r3 && !chainContainsR3(chain) {
-			return chain[0].Subject.CommonName
-		}
-	}
-	return ""
+// tlsVersionNames maps tls.Config version constants to their reporting
+// names.
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS1.0",
+	tls.VersionTLS11: "TLS1.1",
+	tls.VersionTLS12: "TLS1.2",
+	tls.VersionTLS13: "TLS1.3",
 }
 
-// auditHostname for a given hostname, dials and starts a TLS handshake.
-// The tls.Config skips verification steps and delegates verification to
-// an anonymous function that audits the certification chain
-func auditHostname(hostname string) string {
-	var result string
-	dialer := net.Dialer{Timeout: 1 * time.Second}
+// auditHostname dials port on hostname and, for a STARTTLS protocol p,
+// speaks its plaintext preamble before starting a TLS handshake. The
+// tls.Config skips verification steps and delegates verification to an
+// anonymous function that audits the presented certification chain
+// against cfg's rules, falling back to AIA resolution via resolver (may
+// be nil) when the chain is incomplete. It always returns an auditRecord
+// describing the outcome, whether or not a handshake ever completed.
+func auditHostname(cfg *chainaudit.Config, resolver *chainaudit.Resolver, p protocol, port string, hostname string) (rec auditRecord) {
+	start := time.Now()
+	rec = auditRecord{Hostname: hostname}
+	defer func() { rec.DurationMS = time.Since(start).Milliseconds() }()
+
+	var chainResult chainaudit.Result
 	tlsConfig := tls.Config{
 		InsecureSkipVerify: true,
+		ServerName:         hostname,
 		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			misconfiguredCertCN := auditChain(rawCerts)
-			result = misconfiguredCertCN
+			chain := rawToChain(rawCerts)
+			if len(chain) == 0 {
+				return nil
+			}
+			if debugMode == true {
+				fmt.Println(chaing2String(chain))
+			}
+			chainResult = cfg.AuditChain(chain, resolver)
+			rec.LeafCN = chain[0].Subject.CommonName
+			rec.LeafSANs = chain[0].DNSNames
+			notAfter := chain[0].NotAfter
+			rec.LeafNotAfter = &notAfter
+			for _, cert := range chain {
+				rec.Chain = append(rec.Chain, chainCert{
+					CN:         cert.Subject.CommonName,
+					SPKISHA256: chainaudit.SPKIFingerprint(cert),
+				})
+			}
 			return nil
 		},
 	}
-	tls.DialWithDialer(&dialer, "tcp", fmt.Sprintf("%s:443", hostname), &tlsConfig)
-	return result
+
+	addr := fmt.Sprintf("%s:%s", hostname, port)
+	conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
+	if err != nil {
+		rec.DialError = err.Error()
+		rec.Verdict = verdictDialError
+		return rec
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if p != protocolHTTPS {
+		if err := starttls(conn, p, hostname); err != nil {
+			// The TCP dial succeeded; this is the server failing to
+			// negotiate the upgrade to TLS, not a dial failure.
+			rec.HandshakeError = err.Error()
+			rec.Verdict = verdictHandshakeError
+			return rec
+		}
+	}
+
+	tlsConn := tls.Client(conn, &tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		rec.HandshakeError = err.Error()
+		rec.Verdict = verdictHandshakeError
+		return rec
+	}
+
+	state := tlsConn.ConnectionState()
+	rec.TLSVersion = tlsVersionNames[state.Version]
+	rec.ALPN = state.NegotiatedProtocol
+	rec.MatchedIssuer = chainResult.PresentedIssuerCN
+	rec.Verdict = reportVerdict(chainResult, rec.LeafNotAfter)
+	return rec
+}
+
+// loadRoots reads a PEM bundle of trusted root certificates from path
+// into a new x509.CertPool.
+func loadRoots(path string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading roots file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates parsed from roots file %q", path)
+	}
+	return pool, nil
 }
 
 // reverseHostname for a given hostname reverses the hostname from the
@@ -135,25 +181,13 @@ func statsTsvToHostnames(statsTsv string) []string {
 	return hostnames
 }
 
-func main() {
-	flag.BoolVar(&debugMode, "debug", false, "Print full audit output for every hostname")
-	var statsTsv string
-	flag.StringVar(&statsTsv, "stats-tsv-file", "", "path to tsv file produced by stats-exporter")
-	flag.Parse()
-	var hostnames []string
-	if statsTsv != "" {
-		hostnames = statsTsvToHostnames(statsTsv)
-	} else {
-		hostnames = os.Args[1:]
-	}
-
-	if len(hostnames) == 0 {
-		fmt.Print("You must supply at least one hostname via stdin or tsv file using `--stats-tsv-file`")
-		os.Exit(1)
-	}
-
+// auditHostnames runs the worker pool that dials each of hostnames,
+// audits the presented chain against cfg (falling back to resolver, if
+// non-nil, for AIA resolution), and writes the results to stdout in
+// outputFormat.
+func auditHostnames(cfg *chainaudit.Config, resolver *chainaudit.Resolver, p protocol, port string, outputFormat string, hostnames []string) {
 	hnChan := make(chan string, len(hostnames))
-	resChan := make(chan string)
+	resChan := make(chan auditRecord)
 	doneChan := make(chan bool, 1)
 
 	go func() {
@@ -168,22 +202,90 @@ func main() {
 		wg.Add(1)
 		go func() {
 			for hostname := range hnChan {
-				resChan <- auditHostname(hostname)
+				resChan <- auditHostname(cfg, resolver, p, port, hostname)
 			}
 			wg.Done()
 		}()
 	}
 
+	report := newReporter(os.Stdout, outputFormat)
 	go func() {
-		for result := range resChan {
-			if result != "" {
-				fmt.Println(result)
-			}
+		for rec := range resChan {
+			report.record(rec)
 		}
 		doneChan <- true
 	}()
 	wg.Wait()
 	close(resChan)
 	<-doneChan
-	fmt.Println("Done")
+
+	report.finish()
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		runDiscover(os.Args[2:])
+		return
+	}
+
+	flag.BoolVar(&debugMode, "debug", false, "Print full audit output for every hostname")
+	var statsTsv string
+	flag.StringVar(&statsTsv, "stats-tsv-file", "", "path to tsv file produced by stats-exporter")
+	var configFile string
+	flag.StringVar(&configFile, "config", "", "path to a JSON file of chainaudit rules")
+	var rootsFile string
+	flag.StringVar(&rootsFile, "roots", "", "path to a PEM bundle of trusted roots, used to resolve intermediates omitted by a server via AIA")
+	var cacheDir string
+	flag.StringVar(&cacheDir, "cache-dir", "", "directory to cache AIA-fetched intermediates in, keyed by source URL")
+	var protoFlag string
+	flag.StringVar(&protoFlag, "protocol", "https", "protocol to audit: https, smtp, submission, imap, pop3, xmpp")
+	var port string
+	flag.StringVar(&port, "port", "", "port to dial; defaults to the conventional port for -protocol")
+	var outputFormat string
+	flag.StringVar(&outputFormat, "output", "text", "output format: text, json, ndjson, csv")
+	flag.Parse()
+
+	switch outputFormat {
+	case "text", "json", "ndjson", "csv":
+	default:
+		fmt.Printf("Unknown -output format %q\n", outputFormat)
+		os.Exit(1)
+	}
+
+	p := protocol(protoFlag)
+	if port == "" {
+		port = defaultPort(p)
+	}
+
+	if configFile == "" {
+		fmt.Println("You must supply a rule config via -config")
+		os.Exit(1)
+	}
+	cfg, err := chainaudit.LoadConfig(configFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var resolver *chainaudit.Resolver
+	if rootsFile != "" {
+		roots, err := loadRoots(rootsFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		resolver = chainaudit.NewResolver(roots, cacheDir)
+	}
+
+	var hostnames []string
+	if statsTsv != "" {
+		hostnames = statsTsvToHostnames(statsTsv)
+	} else {
+		hostnames = flag.Args()
+	}
+
+	if len(hostnames) == 0 {
+		fmt.Print("You must supply at least one hostname as a command-line argument, a tsv file using `--stats-tsv-file`, or the `discover` subcommand")
+		os.Exit(1)
+	}
+
+	auditHostnames(cfg, resolver, p, port, outputFormat, hostnames)
 }
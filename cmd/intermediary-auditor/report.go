@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/letsencrypt/sre-tools/chainaudit"
+)
+
+// Top-level verdicts surfaced in an auditRecord. These fold in
+// connection-level outcomes (dial_error, handshake_error, expired) that
+// chainaudit.Verdict doesn't know about alongside the chain-audit
+// outcomes it does.
+const (
+	verdictOK                    = "ok"
+	verdictMissingIntermediateOK = "missing_intermediate_resolved"
+	verdictWrongIntermediate     = "wrong_intermediate"
+	verdictMissingIntermediate   = "missing_intermediate"
+	verdictExpired               = "expired"
+	verdictDialError             = "dial_error"
+	verdictHandshakeError        = "handshake_error"
+	verdictNoRule                = "no_rule"
+)
+
+// chainCert is the reporting projection of a single certificate in a
+// presented chain.
+type chainCert struct {
+	CN         string `json:"cn"`
+	SPKISHA256 string `json:"spki_sha256"`
+}
+
+// auditRecord is the structured, per-hostname result of a single audit
+// run, suitable for text, JSON, NDJSON, or CSV output.
+type auditRecord struct {
+	Hostname       string      `json:"hostname"`
+	DialError      string      `json:"dial_error,omitempty"`
+	HandshakeError string      `json:"handshake_error,omitempty"`
+	TLSVersion     string      `json:"tls_version,omitempty"`
+	ALPN           string      `json:"alpn,omitempty"`
+	LeafCN         string      `json:"leaf_cn,omitempty"`
+	LeafSANs       []string    `json:"leaf_sans,omitempty"`
+	LeafNotAfter   *time.Time  `json:"leaf_not_after,omitempty"`
+	Chain          []chainCert `json:"chain,omitempty"`
+	MatchedIssuer  string      `json:"matched_issuer,omitempty"`
+	Verdict        string      `json:"verdict"`
+	DurationMS     int64       `json:"duration_ms"`
+}
+
+// reportVerdict folds a chainaudit.Result and the leaf's expiry into one
+// of the top-level verdict constants. leafNotAfter is nil when no leaf
+// certificate was ever parsed (a dial or handshake failure).
+func reportVerdict(result chainaudit.Result, leafNotAfter *time.Time) string {
+	if leafNotAfter != nil && leafNotAfter.Before(time.Now()) {
+		return verdictExpired
+	}
+	switch result.Verdict {
+	case chainaudit.VerdictOK:
+		return verdictOK
+	case chainaudit.VerdictMissingIntermediateResolved:
+		return verdictMissingIntermediateOK
+	case chainaudit.VerdictWrongIntermediate:
+		return verdictWrongIntermediate
+	case chainaudit.VerdictIncomplete, chainaudit.VerdictMissingIntermediateUnresolved:
+		return verdictMissingIntermediate
+	case chainaudit.VerdictNoRule:
+		return verdictNoRule
+	default:
+		return string(result.Verdict)
+	}
+}
+
+// reporter writes auditRecords in one of the "text", "json", "ndjson",
+// or "csv" formats and, once all records have been written, prints an
+// aggregate count of records per verdict.
+type reporter struct {
+	w       io.Writer
+	format  string
+	csvW    *csv.Writer
+	records []auditRecord
+	summary map[string]int
+}
+
+// newReporter returns a reporter writing in format to w. A format other
+// than "json", "ndjson", or "csv" is written as "text"; callers should
+// validate the flag value before constructing a reporter.
+func newReporter(w io.Writer, format string) *reporter {
+	r := &reporter{w: w, format: format, summary: map[string]int{}}
+	if format == "csv" {
+		r.csvW = csv.NewWriter(w)
+		r.csvW.Write([]string{
+			"hostname", "verdict", "dial_error", "handshake_error",
+			"tls_version", "alpn", "leaf_cn", "leaf_not_after", "duration_ms",
+		})
+	}
+	return r
+}
+
+// record adds rec to the report, writing it immediately for the
+// streaming formats (text, ndjson, csv) and buffering it otherwise
+// (json, which is emitted as a single array).
+func (r *reporter) record(rec auditRecord) {
+	r.summary[rec.Verdict]++
+	switch r.format {
+	case "json":
+		r.records = append(r.records, rec)
+	case "ndjson":
+		data, _ := json.Marshal(rec)
+		fmt.Fprintln(r.w, string(data))
+	case "csv":
+		var leafNotAfter string
+		if rec.LeafNotAfter != nil {
+			leafNotAfter = rec.LeafNotAfter.Format(time.RFC3339)
+		}
+		r.csvW.Write([]string{
+			rec.Hostname, rec.Verdict, rec.DialError, rec.HandshakeError,
+			rec.TLSVersion, rec.ALPN, rec.LeafCN, leafNotAfter,
+			fmt.Sprintf("%d", rec.DurationMS),
+		})
+	default: // text
+		if rec.Verdict != verdictOK {
+			fmt.Fprintf(r.w, "%s: verdict=%s leafCN=%q matchedIssuer=%q\n",
+				rec.Hostname, rec.Verdict, rec.LeafCN, rec.MatchedIssuer)
+		}
+	}
+}
+
+// finish flushes any buffered output and prints the aggregate summary of
+// record counts per verdict.
+func (r *reporter) finish() {
+	switch r.format {
+	case "json":
+		data, _ := json.MarshalIndent(r.records, "", "  ")
+		fmt.Fprintln(r.w, string(data))
+	case "csv":
+		r.csvW.Flush()
+	}
+	for verdict, count := range r.summary {
+		fmt.Fprintf(r.w, "%s: %d\n", verdict, count)
+	}
+}
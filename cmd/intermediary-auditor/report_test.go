@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/sre-tools/chainaudit"
+)
+
+func TestReportVerdict(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-24 * time.Hour)
+
+	cases := []struct {
+		name         string
+		result       chainaudit.Result
+		leafNotAfter *time.Time
+		want         string
+	}{
+		{
+			name:   "ok",
+			result: chainaudit.Result{Verdict: chainaudit.VerdictOK},
+			want:   verdictOK,
+		},
+		{
+			name:         "missing intermediate resolved is reported separately from ok",
+			result:       chainaudit.Result{Verdict: chainaudit.VerdictMissingIntermediateResolved},
+			leafNotAfter: &future,
+			want:         verdictMissingIntermediateOK,
+		},
+		{
+			name:   "wrong intermediate",
+			result: chainaudit.Result{Verdict: chainaudit.VerdictWrongIntermediate},
+			want:   verdictWrongIntermediate,
+		},
+		{
+			name:   "incomplete chain",
+			result: chainaudit.Result{Verdict: chainaudit.VerdictIncomplete},
+			want:   verdictMissingIntermediate,
+		},
+		{
+			name:   "missing intermediate unresolved",
+			result: chainaudit.Result{Verdict: chainaudit.VerdictMissingIntermediateUnresolved},
+			want:   verdictMissingIntermediate,
+		},
+		{
+			name:   "no rule",
+			result: chainaudit.Result{Verdict: chainaudit.VerdictNoRule},
+			want:   verdictNoRule,
+		},
+		{
+			name:         "expired leaf takes priority over an otherwise-ok chain",
+			result:       chainaudit.Result{Verdict: chainaudit.VerdictOK},
+			leafNotAfter: &past,
+			want:         verdictExpired,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := reportVerdict(c.result, c.leafNotAfter)
+			if got != c.want {
+				t.Errorf("reportVerdict() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
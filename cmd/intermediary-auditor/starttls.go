@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// protocol identifies the application protocol auditHostname should
+// speak before (optionally) upgrading the connection to TLS.
+type protocol string
+
+const (
+	protocolHTTPS      protocol = "https"
+	protocolSMTP       protocol = "smtp"
+	protocolSubmission protocol = "submission"
+	protocolIMAP       protocol = "imap"
+	protocolPOP3       protocol = "pop3"
+	protocolXMPP       protocol = "xmpp"
+)
+
+// defaultPort returns the conventional TCP port for p.
+func defaultPort(p protocol) string {
+	switch p {
+	case protocolSMTP:
+		return "25"
+	case protocolSubmission:
+		return "587"
+	case protocolIMAP:
+		return "143"
+	case protocolPOP3:
+		return "110"
+	case protocolXMPP:
+		return "5222"
+	default:
+		return "443"
+	}
+}
+
+// starttls performs the plaintext preamble required to upgrade conn to
+// TLS for p, leaving conn ready to be wrapped with tls.Client. p must
+// not be protocolHTTPS, which never speaks a plaintext preamble.
+func starttls(conn net.Conn, p protocol, hostname string) error {
+	switch p {
+	case protocolSMTP, protocolSubmission:
+		return starttlsSMTP(conn)
+	case protocolIMAP:
+		return starttlsIMAP(conn)
+	case protocolPOP3:
+		return starttlsPOP3(conn)
+	case protocolXMPP:
+		return starttlsXMPP(conn, hostname)
+	default:
+		return fmt.Errorf("protocol %q has no STARTTLS preamble", p)
+	}
+}
+
+// expectLine reads a single CRLF-terminated line from r and requires it
+// to begin with prefix, or "" to accept any line.
+func expectLine(r *bufio.Reader, prefix string) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading response: %s", err)
+	}
+	if prefix != "" && !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("unexpected response: %q", line)
+	}
+	return line, nil
+}
+
+// smtpReadMultiline reads lines from r until one without a hyphen in the
+// fourth column (the terminator of a multi-line SMTP reply), optionally
+// requiring the reply code to start with prefix. It returns the final
+// (terminating) line.
+func smtpReadMultiline(r *bufio.Reader, prefix string) (string, error) {
+	var last string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("reading SMTP response: %s", err)
+		}
+		if prefix != "" && !strings.HasPrefix(line, prefix) {
+			return "", fmt.Errorf("unexpected SMTP response: %q", line)
+		}
+		last = line
+		if len(line) > 3 && line[3] != '-' {
+			break
+		}
+	}
+	return last, nil
+}
+
+// starttlsSMTP issues EHLO and STARTTLS per RFC 3207, used for both the
+// SMTP (25) and submission (587) ports.
+func starttlsSMTP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	// RFC 5321 §4.2 permits a multi-line greeting banner, so the 220
+	// response must be read the same way as the EHLO response below.
+	if _, err := smtpReadMultiline(r, "220"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "EHLO auditor\r\n"); err != nil {
+		return err
+	}
+	if _, err := smtpReadMultiline(r, "250"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	if _, err := expectLine(r, "220"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// starttlsIMAP issues the STARTTLS command per RFC 3501 section 6.2.1.
+func starttlsIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if _, err := expectLine(r, "* OK"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "a1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	if _, err := expectLine(r, "a1 OK"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// starttlsPOP3 issues the STLS command per RFC 2595.
+func starttlsPOP3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if _, err := expectLine(r, "+OK"); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	if _, err := expectLine(r, "+OK"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// starttlsXMPP opens a client-to-server stream and requests STARTTLS
+// per RFC 6120 section 5.
+func starttlsXMPP(conn net.Conn, hostname string) error {
+	_, err := fmt.Fprintf(conn,
+		"<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>",
+		hostname)
+	if err != nil {
+		return err
+	}
+	// The server replies with its own stream header and a <features/>
+	// advertisement before we can request STARTTLS; read and discard
+	// it rather than parsing the full XML stream.
+	buf := make([]byte, 4096)
+	if _, err := conn.Read(buf); err != nil {
+		return fmt.Errorf("reading stream response: %s", err)
+	}
+
+	if _, err := fmt.Fprint(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("reading STARTTLS response: %s", err)
+	}
+	if !strings.Contains(string(buf[:n]), "<proceed") {
+		return fmt.Errorf("server did not proceed with STARTTLS: %q", string(buf[:n]))
+	}
+	return nil
+}
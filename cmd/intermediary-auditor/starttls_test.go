@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+)
+
+// serverWrite writes each of lines to conn as CRLF-terminated text. It
+// does not close conn.
+func serverWrite(conn net.Conn, lines ...string) {
+	for _, line := range lines {
+		io.WriteString(conn, line+"\r\n")
+	}
+}
+
+func TestStarttlsSMTPSingleLineBanner(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		serverWrite(server, "220 mail.example.com ESMTP")
+		if _, err := r.ReadString('\n'); err != nil { // EHLO
+			return
+		}
+		serverWrite(server, "250 mail.example.com")
+		if _, err := r.ReadString('\n'); err != nil { // STARTTLS
+			return
+		}
+		serverWrite(server, "220 Go ahead")
+	}()
+
+	if err := starttlsSMTP(client); err != nil {
+		t.Fatalf("starttlsSMTP() = %v, want nil", err)
+	}
+}
+
+func TestStarttlsSMTPMultiLineBanner(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		// RFC 5321 §4.2 permits a multi-line greeting banner.
+		serverWrite(server, "220-mail.example.com ESMTP", "220-please wait", "220 mail.example.com ESMTP ready")
+		if _, err := r.ReadString('\n'); err != nil { // EHLO
+			return
+		}
+		serverWrite(server, "250-mail.example.com", "250 STARTTLS")
+		if _, err := r.ReadString('\n'); err != nil { // STARTTLS
+			return
+		}
+		serverWrite(server, "220 Go ahead")
+	}()
+
+	if err := starttlsSMTP(client); err != nil {
+		t.Fatalf("starttlsSMTP() = %v, want nil", err)
+	}
+}
+
+func TestStarttlsSMTPBadBanner(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		serverWrite(server, "421 Service not available")
+	}()
+
+	if err := starttlsSMTP(client); err == nil {
+		t.Fatal("starttlsSMTP() = nil, want error for a non-220 banner")
+	}
+}
+
+func TestStarttlsIMAP(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		serverWrite(server, "* OK IMAP4rev1 Service Ready")
+		if _, err := r.ReadString('\n'); err != nil { // a1 STARTTLS
+			return
+		}
+		serverWrite(server, "a1 OK Begin TLS negotiation now")
+	}()
+
+	if err := starttlsIMAP(client); err != nil {
+		t.Fatalf("starttlsIMAP() = %v, want nil", err)
+	}
+}
+
+func TestStarttlsPOP3(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		serverWrite(server, "+OK POP3 server ready")
+		if _, err := r.ReadString('\n'); err != nil { // STLS
+			return
+		}
+		serverWrite(server, "+OK Begin TLS negotiation")
+	}()
+
+	if err := starttlsPOP3(client); err != nil {
+		t.Fatalf("starttlsPOP3() = %v, want nil", err)
+	}
+}